@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// senderConfig describes one named, pre-shared-secret Splunk sender allowed
+// to POST to /webhook.
+type senderConfig struct {
+	Name            string   `json:"name"`
+	Secret          string   `json:"secret"`
+	AllowedCIDRs    []string `json:"allowed_cidrs,omitempty"`
+	DefaultSeverity string   `json:"default_severity,omitempty"`
+	Tag             string   `json:"tag,omitempty"`
+
+	nets []*net.IPNet
+}
+
+type sendersFile struct {
+	Senders []senderConfig `json:"senders"`
+}
+
+func sendersConfigPath() string {
+	if p := strings.TrimSpace(os.Getenv("SENDERS_CONFIG")); p != "" {
+		return p
+	}
+	return "./senders.json"
+}
+
+// signatureHeaderName is the header webhookHandler reads the HMAC signature
+// from; configurable since some Splunk deployments rename outbound headers.
+func signatureHeaderName() string {
+	if h := strings.TrimSpace(os.Getenv("SIGNATURE_HEADER")); h != "" {
+		return h
+	}
+	return "X-Splunk-Signature"
+}
+
+// signatureMaxSkew bounds how old/new a signed timestamp may be before the
+// request is rejected as a replay.
+const signatureMaxSkew = 5 * time.Minute
+
+// adminTokenHeader carries the shared admin token required by mutating
+// /api/senders/* routes (currently just rotate-secret).
+const adminTokenHeader = "X-Admin-Token"
+
+// resolveAdminToken reads the shared admin token from ADMIN_TOKEN. An unset
+// token disables every admin-auth-gated route rather than leaving them open,
+// since (unlike webhook sender auth, which must stay usable out of the box
+// for collectors) these are destructive/secret-revealing operations with no
+// legitimate no-auth use case.
+func resolveAdminToken() string {
+	return strings.TrimSpace(os.Getenv("ADMIN_TOKEN"))
+}
+
+// requireAdminToken checks r against adminToken using a constant-time
+// comparison and writes an error response if it doesn't match (or no token
+// is configured at all). Returns true if the caller is authorized to
+// proceed.
+func requireAdminToken(w http.ResponseWriter, r *http.Request, adminToken string) bool {
+	if adminToken == "" {
+		http.Error(w, "admin endpoint disabled: ADMIN_TOKEN is not configured", http.StatusServiceUnavailable)
+		return false
+	}
+	got := strings.TrimSpace(r.Header.Get(adminTokenHeader))
+	if got == "" || !hmac.Equal([]byte(got), []byte(adminToken)) {
+		http.Error(w, "invalid or missing "+adminTokenHeader, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// SenderRegistry holds the configured senders and their ACLs, with a
+// rotate-secret admin operation that persists back to senders.json.
+type SenderRegistry struct {
+	mu      sync.RWMutex
+	path    string
+	senders map[string]*senderConfig
+}
+
+func newSenderRegistry(path string) (*SenderRegistry, error) {
+	reg := &SenderRegistry{path: path, senders: make(map[string]*senderConfig)}
+	if err := reg.reload(); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+func (reg *SenderRegistry) reload() error {
+	data, err := os.ReadFile(reg.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var f sendersFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	senders := make(map[string]*senderConfig, len(f.Senders))
+	for i := range f.Senders {
+		sc := f.Senders[i]
+		for _, cidr := range sc.AllowedCIDRs {
+			_, ipnet, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("sender %q: invalid CIDR %q: %w", sc.Name, cidr, err)
+			}
+			sc.nets = append(sc.nets, ipnet)
+		}
+		senders[sc.Name] = &sc
+	}
+	reg.mu.Lock()
+	reg.senders = senders
+	reg.mu.Unlock()
+	return nil
+}
+
+func (reg *SenderRegistry) isEmpty() bool {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return len(reg.senders) == 0
+}
+
+func (reg *SenderRegistry) get(name string) (*senderConfig, bool) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	sc, ok := reg.senders[name]
+	return sc, ok
+}
+
+func (reg *SenderRegistry) list() []senderConfig {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	out := make([]senderConfig, 0, len(reg.senders))
+	for _, sc := range reg.senders {
+		redacted := *sc
+		redacted.Secret = ""
+		redacted.nets = nil
+		out = append(out, redacted)
+	}
+	return out
+}
+
+// rotateSecret generates a fresh random secret for name, persists the whole
+// registry back to disk, and returns the new secret (shown once).
+func (reg *SenderRegistry) rotateSecret(name string) (string, error) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	sc, ok := reg.senders[name]
+	if !ok {
+		return "", fmt.Errorf("unknown sender %q", name)
+	}
+	secret, err := randomSecret()
+	if err != nil {
+		return "", err
+	}
+	sc.Secret = secret
+
+	var f sendersFile
+	for _, s := range reg.senders {
+		f.Senders = append(f.Senders, *s)
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(reg.path, data, 0600); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func randomSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func (sc *senderConfig) allows(remoteAddr string) bool {
+	if len(sc.nets) == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range sc.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifySignature checks the `t=<unix>,v1=<hex hmac>` style header against
+// HMAC-SHA256(secret, "<t>.<body>"), rejecting stale/future timestamps to
+// guard against replay.
+func verifySignature(header, secret string, body []byte) error {
+	parts := strings.Split(header, ",")
+	var ts, v1 string
+	for _, p := range parts {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "v1":
+			v1 = kv[1]
+		}
+	}
+	if ts == "" || v1 == "" {
+		return fmt.Errorf("malformed signature header")
+	}
+
+	tsInt, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp in signature header")
+	}
+	skew := time.Since(time.Unix(tsInt, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > signatureMaxSkew {
+		return fmt.Errorf("signature timestamp outside allowed skew")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "." + string(body)))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(v1)) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// authenticateWebhook resolves the named sender from the request, verifies
+// its HMAC signature and CIDR allowlist, and returns the sender config so
+// the caller can tag the resulting alert. If no senders are configured at
+// all, authentication is a no-op (keeps the server usable in dev/test
+// without senders.json) — main logs a startup warning in that case so an
+// operator who meant to ship senders.json doesn't run unauthenticated
+// without knowing it.
+func authenticateWebhook(senders *SenderRegistry, r *http.Request, body []byte) (*senderConfig, error) {
+	if senders == nil || senders.isEmpty() {
+		return nil, nil
+	}
+
+	name := strings.TrimSpace(r.Header.Get("X-Sender-Name"))
+	if name == "" {
+		return nil, fmt.Errorf("missing X-Sender-Name header")
+	}
+	sc, ok := senders.get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown sender %q", name)
+	}
+	if !sc.allows(r.RemoteAddr) {
+		return nil, fmt.Errorf("sender %q: %s is not in the allowed CIDR list", name, r.RemoteAddr)
+	}
+
+	sig := r.Header.Get(signatureHeaderName())
+	if sig == "" {
+		return nil, fmt.Errorf("missing %s header", signatureHeaderName())
+	}
+	if err := verifySignature(sig, sc.Secret, body); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+func handleSenders(senders *SenderRegistry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		var list []senderConfig
+		if senders != nil {
+			list = senders.list()
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"senders": list})
+	}
+}
+
+func handleSenderRotateSecret(senders *SenderRegistry, adminToken string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !requireAdminToken(w, r, adminToken) {
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/api/senders/")
+		name = strings.TrimSuffix(name, "/rotate-secret")
+		if senders == nil {
+			http.Error(w, "no senders configured", http.StatusNotFound)
+			return
+		}
+		secret, err := senders.rotateSecret(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"sender": name,
+			"secret": secret,
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// exprNode is a compiled `when:` expression, evaluated against an event's
+// fields (euid, exe, auid, ...). The grammar is intentionally tiny — just
+// enough for rules.json to express things like:
+//
+//	euid == "0" && exe.startsWith("/tmp/")
+//	alert_type == "RED_EXEC" || alert_type == "RED_NETCONN"
+//
+// A full CEL implementation isn't worth vendoring for this; this covers the
+// comparisons the severity ladder actually needs.
+type exprNode interface {
+	eval(fields map[string]string) bool
+}
+
+type andNode struct{ left, right exprNode }
+
+func (n andNode) eval(f map[string]string) bool { return n.left.eval(f) && n.right.eval(f) }
+
+type orNode struct{ left, right exprNode }
+
+func (n orNode) eval(f map[string]string) bool { return n.left.eval(f) || n.right.eval(f) }
+
+type notNode struct{ inner exprNode }
+
+func (n notNode) eval(f map[string]string) bool { return !n.inner.eval(f) }
+
+type eqNode struct {
+	field  string
+	value  string
+	negate bool
+}
+
+func (n eqNode) eval(f map[string]string) bool {
+	eq := f[n.field] == n.value
+	if n.negate {
+		return !eq
+	}
+	return eq
+}
+
+type methodNode struct {
+	field  string
+	method string // startsWith | contains
+	arg    string
+}
+
+func (n methodNode) eval(f map[string]string) bool {
+	v := f[n.field]
+	switch n.method {
+	case "startsWith":
+		return strings.HasPrefix(v, n.arg)
+	case "contains":
+		return strings.Contains(v, n.arg)
+	default:
+		return false
+	}
+}
+
+type alwaysTrue struct{}
+
+func (alwaysTrue) eval(map[string]string) bool { return true }
+
+// --- parser ---
+
+type exprToken struct {
+	kind string // ident, string, op, lparen, rparen, eof
+	text string
+}
+
+func tokenizeExpr(s string) ([]exprToken, error) {
+	var tokens []exprToken
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '(':
+			tokens = append(tokens, exprToken{"lparen", "("})
+			i++
+		case c == ')':
+			tokens = append(tokens, exprToken{"rparen", ")"})
+			i++
+		case c == '.':
+			tokens = append(tokens, exprToken{"dot", "."})
+			i++
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				j++
+			}
+			if j >= len(s) {
+				return nil, fmt.Errorf("unterminated string literal in expression %q", s)
+			}
+			tokens = append(tokens, exprToken{"string", s[i+1 : j]})
+			i = j + 1
+		case strings.HasPrefix(s[i:], "=="):
+			tokens = append(tokens, exprToken{"op", "=="})
+			i += 2
+		case strings.HasPrefix(s[i:], "!="):
+			tokens = append(tokens, exprToken{"op", "!="})
+			i += 2
+		case strings.HasPrefix(s[i:], "&&"):
+			tokens = append(tokens, exprToken{"op", "&&"})
+			i += 2
+		case strings.HasPrefix(s[i:], "||"):
+			tokens = append(tokens, exprToken{"op", "||"})
+			i += 2
+		case isIdentByte(c):
+			j := i
+			for j < len(s) && isIdentByte(s[j]) {
+				j++
+			}
+			tokens = append(tokens, exprToken{"ident", s[i:j]})
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected character %q in expression %q", c, s)
+		}
+	}
+	tokens = append(tokens, exprToken{"eof", ""})
+	return tokens, nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func parseExpr(s string) (exprNode, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return alwaysTrue{}, nil
+	}
+	tokens, err := tokenizeExpr(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != "eof" {
+		return nil, fmt.Errorf("unexpected trailing input in expression %q", s)
+	}
+	return node, nil
+}
+
+func (p *exprParser) peek() exprToken { return p.tokens[p.pos] }
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if p.pos < len(p.tokens)-1 {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == "op" && p.peek().text == "||" {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == "op" && p.peek().text == "&&" {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.peek().kind == "ident" && p.peek().text == "not" {
+		p.next()
+		inner, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{inner}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	if p.peek().kind == "lparen" {
+		p.next()
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().kind != "rparen" {
+			return nil, fmt.Errorf("expected ) in expression")
+		}
+		p.next()
+		return node, nil
+	}
+
+	if p.peek().kind != "ident" {
+		return nil, fmt.Errorf("expected field name, got %q", p.peek().text)
+	}
+	field := p.next().text
+
+	if p.peek().kind == "dot" {
+		p.next()
+		if p.peek().kind != "ident" {
+			return nil, fmt.Errorf("expected method name after %q.", field)
+		}
+		method := p.next().text
+		if p.peek().kind != "lparen" {
+			return nil, fmt.Errorf("expected ( after %s.%s", field, method)
+		}
+		p.next()
+		if p.peek().kind != "string" {
+			return nil, fmt.Errorf("expected string argument to %s.%s(...)", field, method)
+		}
+		arg := p.next().text
+		if p.peek().kind != "rparen" {
+			return nil, fmt.Errorf("expected ) after %s.%s(%q", field, method, arg)
+		}
+		p.next()
+		return methodNode{field: field, method: method, arg: arg}, nil
+	}
+
+	if p.peek().kind != "op" || (p.peek().text != "==" && p.peek().text != "!=") {
+		return nil, fmt.Errorf("expected == or != after field %q", field)
+	}
+	negate := p.next().text == "!="
+	if p.peek().kind != "string" {
+		return nil, fmt.Errorf("expected string literal on right-hand side of %q", field)
+	}
+	value := p.next().text
+	return eqNode{field: field, value: value, negate: negate}, nil
+}
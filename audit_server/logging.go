@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logger is the process-wide structured logger. JSON output by default
+// (easiest to ship to a log aggregator); level comes from LOG_LEVEL
+// (debug|info|warn|error, default info).
+var logger = newLogger()
+
+func newLogger() *slog.Logger {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: parseLogLevel(os.Getenv("LOG_LEVEL"))})
+	return slog.New(handler)
+}
+
+func parseLogLevel(v string) slog.Level {
+	switch strings.ToUpper(strings.TrimSpace(v)) {
+	case "DEBUG":
+		return slog.LevelDebug
+	case "WARN", "WARNING":
+		return slog.LevelWarn
+	case "ERROR":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// fatalf logs a structured error and exits, replacing log.Fatalf at call
+// sites that need a single formatted message.
+func fatalf(format string, args ...interface{}) {
+	logger.Error(fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
@@ -1,20 +1,21 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/fs"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 )
 
@@ -29,6 +30,8 @@ type Alert struct {
 	SearchName string    `json:"search_name"`
 	AlertType  string    `json:"alert_type"`
 	Severity   string    `json:"severity,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Count      int       `json:"count,omitempty"`
 
 	// Collector-style fields (if the webhook sender is our audit collector).
 	Exe   string `json:"exe,omitempty"`
@@ -68,26 +71,63 @@ type CollectorAlert struct {
 }
 
 var (
-	alerts   []Alert
-	alertsMu sync.Mutex
-	nextID   = 1
-	maxStore = 500 // keep a rolling window to avoid unbounded memory growth
-	dataFile = filepath.Join(".", "alerts_history.json")
+	// maxStore and maxStoreAge are the retention policy enforced by the
+	// store's background compactor (0 disables the respective limit).
+	maxStore    = 500
+	maxStoreAge time.Duration
+	store       Store
+	sinkManager *SinkManager
+	broker      = newBroker()
+	rulesEngine = newRuleEngine()
+	senders     *SenderRegistry
 )
 
 //go:embed web
 var embedded embed.FS
 
 func main() {
+	if len(os.Args) >= 2 && os.Args[1] == "rules" {
+		runRulesCommand(os.Args[2:])
+		return
+	}
+
 	addr := resolveAddr()
+	maxStoreAge = resolveRetentionAge()
 
-	if err := loadHistory(); err != nil {
-		log.Printf("warning: could not load history: %v", err)
+	if err := rulesEngine.Load(rulesConfigPath()); err != nil {
+		logger.Warn("could not load rules", "error", err)
+	}
+
+	reg, err := newSenderRegistry(sendersConfigPath())
+	if err != nil {
+		fatalf("cannot load senders config: %v", err)
+	}
+	senders = reg
+	if senders.isEmpty() {
+		logger.Warn("no senders configured, /webhook signature verification is disabled",
+			"path", sendersConfigPath())
 	}
 
+	adminToken := resolveAdminToken()
+	if adminToken == "" {
+		logger.Warn("ADMIN_TOKEN not set, /api/senders/*/rotate-secret is disabled")
+	}
+
+	s, err := newJSONLStore("./data", maxStore, maxStoreAge)
+	if err != nil {
+		fatalf("cannot open alert store: %v", err)
+	}
+	store = s
+
+	sinkConfigs, err := loadSinkConfigs()
+	if err != nil {
+		logger.Warn("could not load sinks config", "error", err)
+	}
+	sinkManager = newSinkManager(sinkConfigs)
+
 	webFS, err := fs.Sub(embedded, "web")
 	if err != nil {
-		log.Fatalf("cannot load embedded assets: %v", err)
+		fatalf("cannot load embedded assets: %v", err)
 	}
 
 	mux := http.NewServeMux()
@@ -98,12 +138,39 @@ func main() {
 	mux.HandleFunc("/webhook", webhookHandler)
 	mux.HandleFunc("/api/history/reload", reloadHistory)
 	mux.HandleFunc("/api/history/rotate", rotateHistory)
+	mux.HandleFunc("/api/sinks", handleSinks(sinkManager))
+	mux.HandleFunc("/api/sinks/", handleSinkReplay(sinkManager))
+	mux.HandleFunc("/api/alerts/stream", streamAlerts(broker))
+	mux.HandleFunc("/api/rules/reload", handleRulesReload(rulesEngine))
+	mux.HandleFunc("/api/senders", handleSenders(senders))
+	mux.HandleFunc("/api/senders/", handleSenderRotateSecret(senders, adminToken))
+
+	sepMetricsAddr := metricsAddr()
+	if sepMetricsAddr == "" {
+		mux.Handle("/metrics", metricsHandler())
+	}
+	startMetricsListener(sepMetricsAddr)
+
+	logger.Info("listening", "addr", addr)
+
+	srv := &http.Server{Addr: addr, Handler: logRequests(mux)}
+
+	shutdown := make(chan os.Signal, 1)
+	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-shutdown
+		logger.Info("shutting down")
+		broker.Close()
+		rulesEngine.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Error("graceful shutdown failed", "error", err)
+		}
+	}()
 
-	log.Printf("Splunk webhook receiver listening on %s", addr)
-	log.Printf("POST Splunk alerts to http://<ip>%s/webhook", addr)
-
-	if err := http.ListenAndServe(addr, logRequests(mux)); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("server error: %v", err)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fatalf("server error: %v", err)
 	}
 }
 
@@ -118,6 +185,23 @@ func resolveAddr() string {
 	return ":" + val
 }
 
+// resolveRetentionAge reads the age half of the retention policy from
+// HISTORY_MAX_AGE (e.g. "720h" for 30 days), parsed the same way rules.json
+// parses suppress_for. An unset or invalid value disables age-based
+// retention, leaving only the count-based maxStore limit in effect.
+func resolveRetentionAge() time.Duration {
+	val := strings.TrimSpace(os.Getenv("HISTORY_MAX_AGE"))
+	if val == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		logger.Warn("invalid HISTORY_MAX_AGE, ignoring", "value", val, "error", err)
+		return 0
+	}
+	return d
+}
+
 func spaHandler(fsys fs.FS) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
@@ -134,60 +218,92 @@ func spaHandler(fsys fs.FS) http.Handler {
 	})
 }
 
+// rotateHistory now archives a time range atomically instead of swapping the
+// whole history file wholesale; with no body it archives everything received
+// so far, matching the old "start a fresh file" behavior.
 func rotateHistory(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	alertsMu.Lock()
-	defer alertsMu.Unlock()
-
-	ts := time.Now().Format("20060102-150405")
-	newFile := filepath.Join(".", "alerts_history_"+ts+".json")
+	before := time.Now().UTC()
+	if v := strings.TrimSpace(r.URL.Query().Get("before")); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid before: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		before = parsed
+	}
 
-	// Persist current alerts to timestamped file
-	snap := snapshot{Alerts: alerts, NextID: nextID}
-	data, err := json.MarshalIndent(snap, "", "  ")
+	archiveFile, err := store.Rotate(before)
 	if err != nil {
 		http.Error(w, "failed to rotate: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	if err := os.WriteFile(newFile, data, 0644); err != nil {
-		http.Error(w, "failed to rotate: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	// Reset in-memory and start new file
-	alerts = nil
-	nextID = 1
-	dataFile = newFile
-	_ = saveHistoryLocked()
 
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{
 		"status":   "rotated",
-		"filename": filepath.Base(newFile),
+		"filename": archiveFile,
 	})
 }
 
+// getAlerts serves /api/alerts?since=&until=&severity=&host=&q=&limit=&cursor=
+// returning {alerts, next_cursor} for cursor-based pagination over the store.
 func getAlerts(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	alertsMu.Lock()
-	defer alertsMu.Unlock()
+	q := r.URL.Query()
+	filter, err := parseAlertFilter(q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+
+	alerts, nextCursor, err := store.Query(filter, Page{Limit: limit, Cursor: q.Get("cursor")})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
-	if err := enc.Encode(map[string]interface{}{"alerts": alerts}); err != nil {
+	if err := enc.Encode(map[string]interface{}{"alerts": alerts, "next_cursor": nextCursor}); err != nil {
 		http.Error(w, "cannot encode", http.StatusInternalServerError)
 	}
 }
 
+func parseAlertFilter(q url.Values) (Filter, error) {
+	var f Filter
+	if v := strings.TrimSpace(q.Get("since")); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, fmt.Errorf("invalid since: %w", err)
+		}
+		f.Since = t
+	}
+	if v := strings.TrimSpace(q.Get("until")); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return f, fmt.Errorf("invalid until: %w", err)
+		}
+		f.Until = t
+	}
+	f.Severity = strings.TrimSpace(q.Get("severity"))
+	f.Host = strings.TrimSpace(q.Get("host"))
+	f.AlertType = strings.TrimSpace(q.Get("alert_type"))
+	f.AUID = strings.TrimSpace(q.Get("auid"))
+	f.Query = strings.TrimSpace(q.Get("q"))
+	return f, nil
+}
+
 func webhookHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -195,6 +311,8 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var alert Alert
+	var dedupeKey string
+	var suppressFor time.Duration
 
 	rawBody, readErr := io.ReadAll(r.Body)
 	_ = r.Body.Close()
@@ -203,15 +321,29 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	sender, err := authenticateWebhook(senders, r, rawBody)
+	if err != nil {
+		http.Error(w, "unauthorized: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
 	var parseErr error
 	if a, ok, err := tryDecodeCollector(rawBody); err == nil && ok {
-		sev := severityFromCollector(a)
+		decision := rulesEngine.Evaluate(collectorFields(a))
+		sev := decision.Severity
+		title := decision.Title
+		if title == "" {
+			title = collectorTitle(a)
+		}
+		dedupeKey = decision.DedupeKey
+		suppressFor = decision.SuppressFor
 		alert = Alert{
 			ReceivedAt: time.Now().UTC(),
-			Title:      collectorTitle(a),
+			Title:      title,
 			Host:       a.Host,
 			AlertType:  a.Alert,
 			Severity:   sev,
+			Tags:       decision.Tags,
 			Exe:        a.Exe,
 			Comm:       a.Comm,
 			UID:        a.UID,
@@ -226,19 +358,18 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 			RawEv:      a.Raw,
 			Source:     a.Exe,
 			Raw:        json.RawMessage(rawBody),
+			Count:      1,
 		}
 
-		// Human-readable server logs.
-		log.Printf("[SEV=%s][ALERT=%s] host=%s exe=%s auid=%s tty=%s audit=%s pid=%s",
-			sev, a.Alert, a.Host, a.Exe, a.AUID, a.TTY, a.Audit, a.PID)
-		if strings.TrimSpace(a.Text) != "" {
-			log.Printf("  %s", a.Text)
-		}
+		logger.Info("alert received",
+			"severity", sev, "alert_type", a.Alert, "host", a.Host, "exe", a.Exe,
+			"auid", a.AUID, "tty", a.TTY, "audit", a.Audit, "pid", a.PID, "text", strings.TrimSpace(a.Text))
 	} else {
 		// Fallback to generic Splunk-style payloads (JSON or payload=<json>).
 		payload, rawJSON, err := decodePayloadBytes(rawBody)
 		if err != nil {
 			parseErr = err
+			alertsParseErrors.Inc(map[string]string{"reason": "invalid_payload"})
 			alert = Alert{
 				ReceivedAt: time.Now().UTC(),
 				AlertType:  "unparsed",
@@ -255,17 +386,57 @@ func webhookHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	alertsMu.Lock()
-	defer alertsMu.Unlock()
+	if sender != nil {
+		if alert.Severity == "" {
+			alert.Severity = sender.DefaultSeverity
+		}
+		if sender.Tag != "" {
+			alert.Tags = append(alert.Tags, sender.Tag)
+		}
+	}
+
+	var stored Alert
+	isNew := true
+	if existingID, ok := rulesEngine.CheckDedupe(dedupeKey); ok {
+		stored, err = store.Touch(existingID, func(a *Alert) {
+			a.Count++
+			a.ReceivedAt = alert.ReceivedAt
+		})
+		if err != nil {
+			// The dedupe window outlived the alert it points to (rotated or
+			// compacted out from under it); treat it as a cache miss rather
+			// than dropping a valid event.
+			err = nil
+		} else {
+			isNew = false
+		}
+	}
+	if isNew {
+		stored, err = store.Insert(alert)
+		if err == nil && dedupeKey != "" {
+			rulesEngine.RecordDedupe(dedupeKey, stored.ID, suppressFor)
+		}
+	}
+	if err != nil {
+		http.Error(w, "failed to store alert: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	alert = stored
+	// A dedupe hit collapses into the existing row's Count instead of creating
+	// a new alert, so downstream sinks and SSE subscribers must not be
+	// re-notified for every repeat in the burst, or a 1000-event burst still
+	// produces 1000 PagerDuty/Slack/webhook POSTs and 1000 SSE pushes.
+	if isNew {
+		sinkManager.Publish(alert)
+		broker.Publish(alert)
+	}
 
-	if len(alerts) >= maxStore {
-		// drop the oldest to keep memory predictable
-		alerts = alerts[1:]
+	senderName := ""
+	if sender != nil {
+		senderName = sender.Name
 	}
-	alert.ID = nextID
-	nextID++
-	alerts = append(alerts, alert)
-	_ = saveHistoryLocked()
+	alertsReceived.Inc(map[string]string{"sender": senderName, "alert_type": alert.AlertType, "severity": alert.Severity})
+	alertsStored.Set(nil, float64(storedCountOrZero()))
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -285,14 +456,15 @@ func reloadHistory(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if err := loadHistory(); err != nil {
+	if err := store.Reload(); err != nil {
 		http.Error(w, "failed to reload: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	count := store.Len()
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{
 		"status": "reloaded",
-		"count":  len(alerts),
+		"count":  count,
 	})
 }
 
@@ -356,29 +528,6 @@ func tryDecodeCollector(rawBody []byte) (CollectorAlert, bool, error) {
 	return a, true, nil
 }
 
-func severityFromCollector(a CollectorAlert) string {
-	exe := strings.TrimSpace(a.Exe)
-	euid := strings.TrimSpace(a.EUID)
-
-	if euid == "0" {
-		if strings.HasPrefix(exe, "/tmp/") || strings.HasPrefix(exe, "/dev/shm/") || strings.HasPrefix(exe, "/var/tmp/") {
-			return "HIGH"
-		}
-	}
-
-	if exe != "" {
-		allowed := []string{"/usr/bin/", "/bin/", "/usr/sbin/", "/sbin/"}
-		for _, p := range allowed {
-			if strings.HasPrefix(exe, p) {
-				return "LOW"
-			}
-		}
-		return "MED"
-	}
-
-	return "LOW"
-}
-
 func collectorTitle(a CollectorAlert) string {
 	actor := strings.TrimSpace(a.AUID)
 	// Prefer human-readable AUID from raw if present (e.g., AUID="nala").
@@ -431,12 +580,14 @@ func getAlertsText(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	alertsMu.Lock()
-	defer alertsMu.Unlock()
+	alerts, _, err := store.Query(Filter{}, Page{Limit: maxStore})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	for i := len(alerts) - 1; i >= 0; i-- {
-		a := alerts[i]
+	for _, a := range alerts {
 		msg := strings.TrimSpace(a.Text)
 		if msg == "" {
 			msg = strings.TrimSpace(a.RawText)
@@ -515,47 +666,6 @@ func extractAlert(payload map[string]interface{}, raw []byte) Alert {
 	}
 }
 
-type snapshot struct {
-	Alerts []Alert `json:"alerts"`
-	NextID int     `json:"next_id"`
-}
-
-func loadHistory() error {
-	alertsMu.Lock()
-	defer alertsMu.Unlock()
-
-	data, err := os.ReadFile(dataFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
-		}
-		return err
-	}
-	var snap snapshot
-	if err := json.Unmarshal(data, &snap); err != nil {
-		return err
-	}
-	alerts = snap.Alerts
-	if snap.NextID > 0 {
-		nextID = snap.NextID
-	} else {
-		nextID = len(alerts) + 1
-	}
-	return nil
-}
-
-func saveHistoryLocked() error {
-	snap := snapshot{
-		Alerts: alerts,
-		NextID: nextID,
-	}
-	data, err := json.MarshalIndent(snap, "", "  ")
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(dataFile, data, 0644)
-}
-
 func extractResult(payload map[string]interface{}) map[string]interface{} {
 	if v, ok := payload["result"].(map[string]interface{}); ok {
 		return v
@@ -616,6 +726,10 @@ func logRequests(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		next.ServeHTTP(w, r)
-		log.Printf("%s %s (%v)", r.Method, r.URL.Path, time.Since(start).Round(time.Millisecond))
+		elapsed := time.Since(start)
+		logger.Info("request", "method", r.Method, "path", r.URL.Path, "duration", elapsed.Round(time.Millisecond).String())
+		if r.URL.Path == "/webhook" {
+			webhookDuration.Observe(nil, elapsed.Seconds())
+		}
 	})
 }
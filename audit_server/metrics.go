@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// This module has no vendored client_golang, so Counter/Gauge/Histogram
+// below are a minimal hand-rolled registry that emits the same Prometheus
+// text exposition format client_golang's promhttp.Handler would.
+
+func labelsKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+	}
+	return b.String()
+}
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%s=%q", k, labels[k])
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// Counter is a monotonically increasing value, optionally partitioned by
+// label set (e.g. splunk_alerts_received_total{sender,alert_type,severity}).
+type Counter struct {
+	name   string
+	help   string
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string]map[string]string
+}
+
+func newCounter(name, help string) *Counter {
+	return &Counter{name: name, help: help, values: make(map[string]float64), labels: make(map[string]map[string]string)}
+}
+
+func (c *Counter) Inc(labels map[string]string) { c.Add(labels, 1) }
+
+func (c *Counter) Add(labels map[string]string, delta float64) {
+	key := labelsKey(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[key] += delta
+	c.labels[key] = labels
+}
+
+func (c *Counter) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for key, v := range c.values {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels[key]), strconv.FormatFloat(v, 'g', -1, 64))
+	}
+}
+
+// Gauge is a point-in-time value that can go up or down (queue depth, bytes
+// on disk, current alert count).
+type Gauge struct {
+	name   string
+	help   string
+	mu     sync.Mutex
+	values map[string]float64
+	labels map[string]map[string]string
+}
+
+func newGauge(name, help string) *Gauge {
+	return &Gauge{name: name, help: help, values: make(map[string]float64), labels: make(map[string]map[string]string)}
+}
+
+func (g *Gauge) Set(labels map[string]string, v float64) {
+	key := labelsKey(labels)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[key] = v
+	g.labels[key] = labels
+}
+
+func (g *Gauge) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+	for key, v := range g.values {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels[key]), strconv.FormatFloat(v, 'g', -1, 64))
+	}
+}
+
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type histogramSeries struct {
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// Histogram buckets observations (e.g. splunk_webhook_duration_seconds).
+type Histogram struct {
+	name    string
+	help    string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+	labels  map[string]map[string]string
+}
+
+func newHistogram(name, help string, buckets []float64) *Histogram {
+	return &Histogram{name: name, help: help, buckets: buckets, series: make(map[string]*histogramSeries), labels: make(map[string]map[string]string)}
+}
+
+func (h *Histogram) Observe(labels map[string]string, v float64) {
+	key := labelsKey(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{buckets: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+		h.labels[key] = labels
+	}
+	for i, le := range h.buckets {
+		if v <= le {
+			s.buckets[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for key, s := range h.series {
+		base := h.labels[key]
+		for i, le := range h.buckets {
+			lbls := mergeLabels(base, map[string]string{"le": strconv.FormatFloat(le, 'g', -1, 64)})
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(lbls), s.buckets[i])
+		}
+		lbls := mergeLabels(base, map[string]string{"le": "+Inf"})
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(lbls), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(base), strconv.FormatFloat(s.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(base), s.count)
+	}
+}
+
+func mergeLabels(base, extra map[string]string) map[string]string {
+	out := make(map[string]string, len(base)+len(extra))
+	for k, v := range base {
+		out[k] = v
+	}
+	for k, v := range extra {
+		out[k] = v
+	}
+	return out
+}
+
+var (
+	alertsReceived    = newCounter("splunk_alerts_received_total", "Alerts received by sender, alert type and severity.")
+	alertsParseErrors = newCounter("splunk_alerts_parse_errors_total", "Webhook bodies that failed to parse, by reason.")
+	webhookDuration   = newHistogram("splunk_webhook_duration_seconds", "Latency of /webhook requests.", defaultBuckets)
+	alertsStored      = newGauge("splunk_alerts_stored", "Alerts currently held in the store.")
+	historyBytes      = newGauge("splunk_history_bytes", "Approximate size on disk of the alert store.")
+	sinkDelivery      = newCounter("splunk_sink_delivery_total", "Sink delivery attempts, by sink and result.")
+	sinkQueueDepth    = newGauge("splunk_sink_queue_depth", "Pending entries in each sink's durable DLQ.")
+)
+
+func storedCountOrZero() int {
+	if store == nil {
+		return 0
+	}
+	return store.Len()
+}
+
+func metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		alertsStored.Set(nil, float64(storedCountOrZero()))
+		if store != nil {
+			if n, err := store.DiskUsage(); err == nil {
+				historyBytes.Set(nil, float64(n))
+			}
+		}
+		if sinkManager != nil {
+			for _, s := range sinkManager.status() {
+				sinkQueueDepth.Set(map[string]string{"sink": s["name"].(string)}, float64(s["queue_depth"].(int)))
+			}
+		}
+		alertsReceived.write(w)
+		alertsParseErrors.write(w)
+		webhookDuration.write(w)
+		alertsStored.write(w)
+		historyBytes.write(w)
+		sinkDelivery.write(w)
+		sinkQueueDepth.write(w)
+	})
+}
+
+// metricsAddr reads METRICS_ADDR, the optional separate listener address for
+// /metrics. Empty means /metrics is served on the main mux instead.
+func metricsAddr() string {
+	return strings.TrimSpace(os.Getenv("METRICS_ADDR"))
+}
+
+// startMetricsListener serves /metrics on its own listener (METRICS_ADDR) so
+// it can be firewalled off separately from the public webhook port. No-op
+// if addr is empty; the caller is responsible for mounting /metrics on the
+// main mux instead in that case, not both.
+func startMetricsListener(addr string) {
+	if addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metricsHandler())
+	go func() {
+		logger.Info("metrics listening", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Error("metrics listener failed", "error", err)
+		}
+	}()
+}
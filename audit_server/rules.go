@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ruleJSON is the on-disk shape of one rule. Keep it JSON (like sinks.json
+// and senders.json) rather than pulling in a YAML/CEL dependency the rest of
+// this module doesn't have.
+type ruleJSON struct {
+	Name string `json:"name"`
+	When string `json:"when"`
+	Set  struct {
+		Severity string   `json:"severity,omitempty"`
+		Tags     []string `json:"tags,omitempty"`
+		Title    string   `json:"title,omitempty"`
+	} `json:"set"`
+	DedupeKey   string `json:"dedupe_key,omitempty"`
+	SuppressFor string `json:"suppress_for,omitempty"` // e.g. "30s", parsed with time.ParseDuration
+}
+
+type rule struct {
+	name        string
+	when        exprNode
+	severity    string
+	tags        []string
+	title       string
+	dedupeKey   string
+	suppressFor time.Duration
+}
+
+func compileRule(rj ruleJSON) (*rule, error) {
+	node, err := parseExpr(rj.When)
+	if err != nil {
+		return nil, fmt.Errorf("rule %q: %w", rj.Name, err)
+	}
+	var suppressFor time.Duration
+	if rj.SuppressFor != "" {
+		suppressFor, err = time.ParseDuration(rj.SuppressFor)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: invalid suppress_for: %w", rj.Name, err)
+		}
+	}
+	return &rule{
+		name:        rj.Name,
+		when:        node,
+		severity:    rj.Set.Severity,
+		tags:        rj.Set.Tags,
+		title:       rj.Set.Title,
+		dedupeKey:   rj.DedupeKey,
+		suppressFor: suppressFor,
+	}, nil
+}
+
+// RuleEngine evaluates CollectorAlert fields against a ruleset loaded from
+// disk, and tracks dedupe windows so bursts of the same event within
+// suppress_for collapse into a count on the existing alert (alertmanager-
+// style grouping) instead of creating new ones.
+type RuleEngine struct {
+	mu    sync.RWMutex
+	rules []*rule
+
+	dedupeMu  sync.Mutex
+	dedupe    map[string]dedupeEntry
+	stopSweep chan struct{}
+}
+
+type dedupeEntry struct {
+	alertID int
+	expires time.Time
+}
+
+// Decision is the outcome of evaluating one event against the ruleset.
+type Decision struct {
+	Rule        string
+	Severity    string
+	Tags        []string
+	Title       string
+	DedupeKey   string
+	SuppressFor time.Duration
+}
+
+func rulesConfigPath() string {
+	if p := strings.TrimSpace(os.Getenv("RULES_CONFIG")); p != "" {
+		return p
+	}
+	return "./rules.json"
+}
+
+func newRuleEngine() *RuleEngine {
+	e := &RuleEngine{dedupe: make(map[string]dedupeEntry), stopSweep: make(chan struct{})}
+	go e.sweepLoop()
+	return e
+}
+
+// sweepLoop periodically evicts expired dedupe entries so dedupe_key
+// templates that aren't reused forever (e.g. ones keyed on pid) don't grow
+// the map without bound on a long-running server. Mirrors the store's
+// compactLoop/ticker pattern.
+func (e *RuleEngine) sweepLoop() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.stopSweep:
+			return
+		case <-ticker.C:
+			e.sweepDedupe()
+		}
+	}
+}
+
+func (e *RuleEngine) sweepDedupe() {
+	now := time.Now()
+	e.dedupeMu.Lock()
+	defer e.dedupeMu.Unlock()
+	for key, entry := range e.dedupe {
+		if now.After(entry.expires) {
+			delete(e.dedupe, key)
+		}
+	}
+}
+
+// Close stops the background dedupe sweep. Safe to call once at shutdown.
+func (e *RuleEngine) Close() {
+	close(e.stopSweep)
+}
+
+// Load (re)reads the ruleset from disk. Safe to call while the engine is
+// serving traffic — used for both startup and /api/rules/reload.
+func (e *RuleEngine) Load(path string) error {
+	rules, err := loadRules(path)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+	return nil
+}
+
+func loadRules(path string) ([]*rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var raw struct {
+		Rules []ruleJSON `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	rules := make([]*rule, 0, len(raw.Rules))
+	for _, rj := range raw.Rules {
+		r, err := compileRule(rj)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Evaluate runs fields through the ruleset in order and returns the first
+// match. If no rule matches, Severity falls back to the legacy hardcoded
+// ladder so an empty/missing ruleset behaves exactly like before.
+func (e *RuleEngine) Evaluate(fields map[string]string) Decision {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	for _, r := range e.rules {
+		if !r.when.eval(fields) {
+			continue
+		}
+		d := Decision{Rule: r.name, Severity: r.severity, Tags: r.tags, SuppressFor: r.suppressFor}
+		d.Title = renderTemplate(r.title, fields)
+		if r.dedupeKey != "" {
+			d.DedupeKey = renderTemplate(r.dedupeKey, fields)
+		}
+		return d
+	}
+	return Decision{Severity: legacySeverityFromFields(fields)}
+}
+
+// legacySeverityFromFields mirrors the original hardcoded severityFromCollector
+// ladder, kept as the fallback when no rule in rules.json matches an event.
+func legacySeverityFromFields(fields map[string]string) string {
+	exe := strings.TrimSpace(fields["exe"])
+	euid := strings.TrimSpace(fields["euid"])
+
+	if euid == "0" {
+		if strings.HasPrefix(exe, "/tmp/") || strings.HasPrefix(exe, "/dev/shm/") || strings.HasPrefix(exe, "/var/tmp/") {
+			return "HIGH"
+		}
+	}
+	if exe != "" {
+		for _, p := range []string{"/usr/bin/", "/bin/", "/usr/sbin/", "/sbin/"} {
+			if strings.HasPrefix(exe, p) {
+				return "LOW"
+			}
+		}
+		return "MED"
+	}
+	return "LOW"
+}
+
+// CheckDedupe looks up an in-flight suppression window for key. If one is
+// live it returns the alert ID the caller should bump instead of inserting.
+func (e *RuleEngine) CheckDedupe(key string) (existingID int, ok bool) {
+	if key == "" {
+		return 0, false
+	}
+	e.dedupeMu.Lock()
+	defer e.dedupeMu.Unlock()
+
+	entry, found := e.dedupe[key]
+	if !found || time.Now().After(entry.expires) {
+		return 0, false
+	}
+	return entry.alertID, true
+}
+
+// RecordDedupe opens (or refreshes) the suppression window for key once a
+// fresh alert has actually been inserted under alertID.
+func (e *RuleEngine) RecordDedupe(key string, alertID int, window time.Duration) {
+	if key == "" || window <= 0 {
+		return
+	}
+	e.dedupeMu.Lock()
+	defer e.dedupeMu.Unlock()
+	e.dedupe[key] = dedupeEntry{alertID: alertID, expires: time.Now().Add(window)}
+}
+
+func renderTemplate(tmpl string, fields map[string]string) string {
+	if tmpl == "" {
+		return ""
+	}
+	out := tmpl
+	for k, v := range fields {
+		out = strings.ReplaceAll(out, "{{"+k+"}}", v)
+	}
+	return out
+}
+
+func collectorFields(a CollectorAlert) map[string]string {
+	return map[string]string{
+		"alert": a.Alert,
+		"host":  a.Host,
+		"exe":   a.Exe,
+		"comm":  a.Comm,
+		"uid":   a.UID,
+		"euid":  a.EUID,
+		"auid":  a.AUID,
+		"pid":   a.PID,
+		"ppid":  a.PPID,
+		"tty":   a.TTY,
+		"key":   a.Key,
+		"audit": a.Audit,
+		"text":  a.Text,
+		"raw":   a.Raw,
+	}
+}
+
+// runRulesTest implements the `rules test <rules.json> <corpus-dir>`
+// subcommand: it loads a ruleset and runs it against a corpus of recorded
+// raw CollectorAlert payloads (one JSON object per line, optionally with an
+// "expect_severity" field), printing a diff for every mismatch so operators
+// can safely edit rules before deploying them.
+func runRulesTest(rulesPath, corpusDir string) error {
+	rules, err := loadRules(rulesPath)
+	if err != nil {
+		return fmt.Errorf("loading rules: %w", err)
+	}
+	engine := &RuleEngine{rules: rules, dedupe: make(map[string]dedupeEntry)}
+
+	entries, err := os.ReadDir(corpusDir)
+	if err != nil {
+		return fmt.Errorf("reading corpus dir: %w", err)
+	}
+
+	var total, mismatches int
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := corpusDir + "/" + entry.Name()
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			var corpus struct {
+				CollectorAlert
+				ExpectSeverity string `json:"expect_severity"`
+			}
+			if err := json.Unmarshal([]byte(line), &corpus); err != nil {
+				fmt.Printf("%s: skipping unparsable line: %v\n", entry.Name(), err)
+				continue
+			}
+			total++
+			decision := engine.Evaluate(collectorFields(corpus.CollectorAlert))
+			if corpus.ExpectSeverity != "" && decision.Severity != corpus.ExpectSeverity {
+				mismatches++
+				fmt.Printf("%s: exe=%s auid=%s: expected severity=%s, got=%s (rule=%s)\n",
+					entry.Name(), corpus.Exe, corpus.AUID, corpus.ExpectSeverity, decision.Severity, decision.Rule)
+			}
+		}
+		f.Close()
+	}
+	fmt.Printf("%d payloads evaluated, %d mismatches\n", total, mismatches)
+	return nil
+}
+
+// runRulesCommand implements the `rules test <rules.json> <corpus-dir>`
+// subcommand dispatched from main before the HTTP server starts.
+func runRulesCommand(args []string) {
+	if len(args) != 3 || args[0] != "test" {
+		fmt.Println("usage: audit_server rules test <rules.json> <corpus-dir>")
+		os.Exit(2)
+	}
+	if err := runRulesTest(args[1], args[2]); err != nil {
+		fmt.Println("error:", err)
+		os.Exit(1)
+	}
+}
+
+func handleRulesReload(engine *RuleEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := engine.Load(rulesConfigPath()); err != nil {
+			http.Error(w, "failed to reload rules: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "reloaded"})
+	}
+}
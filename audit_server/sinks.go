@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink is a downstream destination that alerts are fanned out to.
+type Sink interface {
+	Name() string
+	Deliver(alert Alert) error
+	Close() error
+}
+
+// SinkFilter decides whether a given alert should be routed to a sink.
+type SinkFilter struct {
+	MinSeverity string `json:"min_severity,omitempty"`
+	AlertType   string `json:"alert_type,omitempty"` // regexp, matched against Alert.AlertType
+	alertTypeRe *regexp.Regexp
+}
+
+var severityRank = map[string]int{"LOW": 0, "MED": 1, "HIGH": 2}
+
+func (f SinkFilter) matches(a Alert) bool {
+	if f.MinSeverity != "" {
+		if severityRank[strings.ToUpper(a.Severity)] < severityRank[strings.ToUpper(f.MinSeverity)] {
+			return false
+		}
+	}
+	if f.alertTypeRe != nil && !f.alertTypeRe.MatchString(a.AlertType) {
+		return false
+	}
+	return true
+}
+
+// sinkConfig is the on-disk description of one configured sink. We keep this
+// as plain JSON (rather than pulling in a YAML library) since the rest of
+// this server already leans on encoding/json for every other config/state
+// file (alerts_history.json, rules, senders).
+type sinkConfig struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"` // slack | pagerduty | elasticsearch | webhook | syslog ("kafka" is rejected: no client vendored yet)
+	Settings map[string]string `json:"settings"`
+	Filter   SinkFilter        `json:"filter"`
+}
+
+type sinksFile struct {
+	Sinks []sinkConfig `json:"sinks"`
+}
+
+// sinksConfigPath mirrors resolveAddr's env-with-default pattern.
+func sinksConfigPath() string {
+	if p := strings.TrimSpace(os.Getenv("SINKS_CONFIG")); p != "" {
+		return p
+	}
+	return "./sinks.json"
+}
+
+func loadSinkConfigs() ([]sinkConfig, error) {
+	data, err := os.ReadFile(sinksConfigPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var f sinksFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	for i := range f.Sinks {
+		if pat := f.Sinks[i].Filter.AlertType; pat != "" {
+			re, err := regexp.Compile(pat)
+			if err != nil {
+				return nil, fmt.Errorf("sink %q: invalid alert_type filter: %w", f.Sinks[i].Name, err)
+			}
+			f.Sinks[i].Filter.alertTypeRe = re
+		}
+	}
+	return f.Sinks, nil
+}
+
+func buildSink(cfg sinkConfig) (Sink, error) {
+	switch cfg.Type {
+	case "slack":
+		return &webhookPostSink{name: cfg.Name, url: cfg.Settings["webhook_url"], render: renderSlackPayload, client: http.Client{Timeout: sinkHTTPTimeout}}, nil
+	case "pagerduty":
+		return &webhookPostSink{name: cfg.Name, url: "https://events.pagerduty.com/v2/enqueue", render: renderPagerDutyPayload(cfg.Settings["routing_key"]), client: http.Client{Timeout: sinkHTTPTimeout}}, nil
+	case "elasticsearch":
+		return &webhookPostSink{name: cfg.Name, url: strings.TrimRight(cfg.Settings["url"], "/") + "/_bulk", render: renderElasticsearchBulkPayload(cfg.Settings["index"]), client: http.Client{Timeout: sinkHTTPTimeout}}, nil
+	case "webhook":
+		return &webhookPostSink{name: cfg.Name, url: cfg.Settings["url"], render: renderRawJSONPayload, client: http.Client{Timeout: sinkHTTPTimeout}}, nil
+	case "syslog":
+		return newSyslogSink(cfg.Name, cfg.Settings["network"], cfg.Settings["addr"])
+	case "kafka":
+		return nil, fmt.Errorf("sink %q: kafka producer not implemented (no client vendored in this module); remove this sink from the config until one is added", cfg.Name)
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", cfg.Type)
+	}
+}
+
+// webhookPostSink covers Slack, PagerDuty, Elasticsearch bulk and plain
+// webhook sinks: they all boil down to "render the alert, POST it somewhere".
+type webhookPostSink struct {
+	name   string
+	url    string
+	render func(Alert) ([]byte, string, error) // body, content-type
+	client http.Client
+}
+
+func (s *webhookPostSink) Name() string { return s.name }
+
+func (s *webhookPostSink) Deliver(alert Alert) error {
+	body, contentType, err := s.render(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sink %s: %s returned %s", s.name, s.url, resp.Status)
+	}
+	return nil
+}
+
+func (s *webhookPostSink) Close() error { return nil }
+
+func renderSlackPayload(a Alert) ([]byte, string, error) {
+	text := fmt.Sprintf("[%s] %s — %s", orDash(a.Severity), orDash(a.AlertType), a.Title)
+	body, err := json.Marshal(map[string]string{"text": text})
+	return body, "application/json", err
+}
+
+func renderPagerDutyPayload(routingKey string) func(Alert) ([]byte, string, error) {
+	return func(a Alert) ([]byte, string, error) {
+		body, err := json.Marshal(map[string]interface{}{
+			"routing_key":  routingKey,
+			"event_action": "trigger",
+			"dedup_key":    fmt.Sprintf("alert-%d", a.ID),
+			"payload": map[string]interface{}{
+				"summary":  a.Title,
+				"source":   orDash(a.Host),
+				"severity": strings.ToLower(a.Severity),
+				"custom_details": map[string]string{
+					"alert_type": a.AlertType,
+					"exe":        a.Exe,
+					"auid":       a.AUID,
+				},
+			},
+		})
+		return body, "application/json", err
+	}
+}
+
+func renderElasticsearchBulkPayload(index string) func(Alert) ([]byte, string, error) {
+	if index == "" {
+		index = "splunk-alerts"
+	}
+	return func(a Alert) ([]byte, string, error) {
+		action, err := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index}})
+		if err != nil {
+			return nil, "", err
+		}
+		doc, err := json.Marshal(a)
+		if err != nil {
+			return nil, "", err
+		}
+		var buf bytes.Buffer
+		buf.Write(action)
+		buf.WriteByte('\n')
+		buf.Write(doc)
+		buf.WriteByte('\n')
+		return buf.Bytes(), "application/x-ndjson", nil
+	}
+}
+
+func renderRawJSONPayload(a Alert) ([]byte, string, error) {
+	body, err := json.Marshal(a)
+	return body, "application/json", err
+}
+
+// syslogSink forwards alerts to a syslog collector over TCP/UDP using RFC
+// 3164-style framing. We avoid log/syslog here since it only targets the
+// local syslog daemon; remote collectors are the common case for this sink.
+type syslogSink struct {
+	name string
+	conn interface {
+		Write([]byte) (int, error)
+		Close() error
+	}
+}
+
+func newSyslogSink(name, network, addr string) (*syslogSink, error) {
+	if network == "" {
+		network = "udp"
+	}
+	conn, err := net.Dial(network, addr)
+	if err != nil {
+		return nil, fmt.Errorf("syslog sink %s: %w", name, err)
+	}
+	return &syslogSink{name: name, conn: conn}, nil
+}
+
+func (s *syslogSink) Name() string { return s.name }
+
+func (s *syslogSink) Deliver(alert Alert) error {
+	msg := fmt.Sprintf("<13>%s %s splunk-alerts: [%s][%s] %s\n",
+		alert.ReceivedAt.UTC().Format(time.RFC3339), orDash(alert.Host), orDash(alert.Severity), orDash(alert.AlertType), alert.Title)
+	_, err := s.conn.Write([]byte(msg))
+	return err
+}
+
+func (s *syslogSink) Close() error { return s.conn.Close() }
+
+// SinkManager fans out stored alerts to every configured Sink asynchronously,
+// with a bounded worker pool per sink, exponential-backoff retry, and a
+// durable on-disk queue so alerts survive a downstream outage.
+type SinkManager struct {
+	mu      sync.Mutex
+	workers []*sinkWorker
+}
+
+type sinkWorker struct {
+	sink      Sink
+	filter    SinkFilter
+	queuePath string
+	jobs      chan Alert
+
+	mu                  sync.Mutex
+	queueDepth          int
+	lastResult          string // "delivered" | "failed", empty until the first attempt
+	lastError           string
+	consecutiveFailures int
+	lastAttempt         time.Time
+}
+
+const sinkWorkerPoolSize = 4
+const sinkMaxRetries = 5
+
+// sinkHTTPTimeout bounds how long a webhookPostSink waits for a downstream
+// response. Without it, a sink that accepts the connection but never
+// replies hangs the worker goroutine forever instead of failing into the
+// retry/backoff path like an unreachable sink does.
+const sinkHTTPTimeout = 5 * time.Second
+
+func newSinkManager(configs []sinkConfig) *SinkManager {
+	mgr := &SinkManager{}
+	if err := os.MkdirAll("./queue", 0755); err != nil {
+		logger.Warn("could not create sink queue dir", "error", err)
+	}
+	for _, cfg := range configs {
+		sink, err := buildSink(cfg)
+		if err != nil {
+			logger.Warn("skipping sink", "sink", cfg.Name, "error", err)
+			continue
+		}
+		queuePath := filepath.Join("./queue", cfg.Name+".jsonl")
+		w := &sinkWorker{
+			sink:       sink,
+			filter:     cfg.Filter,
+			queuePath:  queuePath,
+			jobs:       make(chan Alert, 256),
+			queueDepth: countQueueDepth(queuePath),
+		}
+		for i := 0; i < sinkWorkerPoolSize; i++ {
+			go w.run()
+		}
+		mgr.workers = append(mgr.workers, w)
+	}
+	return mgr
+}
+
+// Publish hands an alert to every sink whose filter matches. Delivery happens
+// asynchronously; Publish never blocks on a downstream being slow/down.
+func (m *SinkManager) Publish(alert Alert) {
+	if m == nil {
+		return
+	}
+	for _, w := range m.workers {
+		if !w.filter.matches(alert) {
+			continue
+		}
+		select {
+		case w.jobs <- alert:
+		default:
+			// Queue channel is full; fall straight through to the durable
+			// on-disk queue so the alert isn't dropped.
+			w.enqueue(alert)
+		}
+	}
+}
+
+func (w *sinkWorker) run() {
+	for alert := range w.jobs {
+		if err := w.deliverWithRetry(alert); err != nil {
+			logger.Warn("sink giving up on alert after retries", "sink", w.sink.Name(), "alert_id", alert.ID, "error", err)
+			sinkDelivery.Inc(map[string]string{"sink": w.sink.Name(), "result": "failed"})
+			w.recordResult(false, err)
+			w.enqueue(alert)
+			continue
+		}
+		sinkDelivery.Inc(map[string]string{"sink": w.sink.Name(), "result": "delivered"})
+		w.recordResult(true, nil)
+	}
+}
+
+// recordResult tracks the outcome of the most recent delivery attempt so
+// /api/sinks can tell a wedged sink (rising consecutive_failures) apart from
+// one that's simply idle (no alerts routed to it yet).
+func (w *sinkWorker) recordResult(ok bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.lastAttempt = time.Now()
+	if ok {
+		w.lastResult = "delivered"
+		w.lastError = ""
+		w.consecutiveFailures = 0
+		return
+	}
+	w.lastResult = "failed"
+	w.consecutiveFailures++
+	if err != nil {
+		w.lastError = err.Error()
+	}
+}
+
+func (w *sinkWorker) deliverWithRetry(alert Alert) error {
+	var err error
+	for attempt := 0; attempt < sinkMaxRetries; attempt++ {
+		if attempt > 0 {
+			backoff := time.Duration(1<<uint(attempt-1)) * time.Second
+			backoff += time.Duration(rand.Intn(250)) * time.Millisecond
+			time.Sleep(backoff)
+		}
+		if err = w.sink.Deliver(alert); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (w *sinkWorker) enqueue(alert Alert) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f, err := os.OpenFile(w.queuePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Error("sink failed to open DLQ", "sink", w.sink.Name(), "path", w.queuePath, "error", err)
+		return
+	}
+	defer f.Close()
+
+	data, err := json.Marshal(alert)
+	if err != nil {
+		logger.Error("sink failed to marshal alert for DLQ", "sink", w.sink.Name(), "alert_id", alert.ID, "error", err)
+		return
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		logger.Error("sink failed to append to DLQ", "sink", w.sink.Name(), "path", w.queuePath, "error", err)
+		return
+	}
+	w.queueDepth++
+}
+
+// replay re-attempts delivery of every alert queued in the DLQ, dropping
+// whichever ones succeed and rewriting the file with the rest.
+func (w *sinkWorker) replay() (delivered, remaining int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	f, err := os.Open(w.queuePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, 0, nil
+		}
+		return 0, 0, err
+	}
+
+	var failed []Alert
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var alert Alert
+		if err := json.Unmarshal(scanner.Bytes(), &alert); err != nil {
+			continue
+		}
+		if err := w.sink.Deliver(alert); err != nil {
+			failed = append(failed, alert)
+			continue
+		}
+		delivered++
+	}
+	f.Close()
+
+	var buf bytes.Buffer
+	for _, alert := range failed {
+		data, err := json.Marshal(alert)
+		if err != nil {
+			continue
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+	if err := os.WriteFile(w.queuePath, buf.Bytes(), 0644); err != nil {
+		return delivered, len(failed), err
+	}
+	w.queueDepth = len(failed)
+	return delivered, len(failed), nil
+}
+
+// countQueueDepth counts the lines already sitting in a sink's durable DLQ
+// on disk, so a worker restarted with a backlog from a prior run reports its
+// real queue depth immediately instead of showing 0 until the next replay.
+func countQueueDepth(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+func (m *SinkManager) find(name string) *sinkWorker {
+	for _, w := range m.workers {
+		if w.sink.Name() == name {
+			return w
+		}
+	}
+	return nil
+}
+
+func (m *SinkManager) status() []map[string]interface{} {
+	out := make([]map[string]interface{}, 0, len(m.workers))
+	for _, w := range m.workers {
+		w.mu.Lock()
+		depth := w.queueDepth
+		lastResult := w.lastResult
+		lastError := w.lastError
+		failures := w.consecutiveFailures
+		lastAttempt := w.lastAttempt
+		w.mu.Unlock()
+
+		health := "idle"
+		if !lastAttempt.IsZero() {
+			if failures > 0 {
+				health = "unhealthy"
+			} else {
+				health = "healthy"
+			}
+		}
+		entry := map[string]interface{}{
+			"name":                 w.sink.Name(),
+			"queue_depth":          depth,
+			"health":               health,
+			"consecutive_failures": failures,
+			"last_result":          lastResult,
+		}
+		if lastError != "" {
+			entry["last_error"] = lastError
+		}
+		if !lastAttempt.IsZero() {
+			entry["last_attempt"] = lastAttempt.UTC().Format(time.RFC3339)
+		}
+		out = append(out, entry)
+	}
+	return out
+}
+
+func handleSinks(mgr *SinkManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		var sinks []map[string]interface{}
+		if mgr != nil {
+			sinks = mgr.status()
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"sinks": sinks})
+	}
+}
+
+func handleSinkReplay(mgr *SinkManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/api/sinks/")
+		name = strings.TrimSuffix(name, "/replay")
+		if mgr == nil {
+			http.Error(w, "no sinks configured", http.StatusNotFound)
+			return
+		}
+		worker := mgr.find(name)
+		if worker == nil {
+			http.Error(w, "unknown sink: "+name, http.StatusNotFound)
+			return
+		}
+		delivered, remaining, err := worker.replay()
+		if err != nil {
+			http.Error(w, "replay failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"sink":      name,
+			"delivered": delivered,
+			"remaining": remaining,
+		})
+	}
+}
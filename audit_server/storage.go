@@ -0,0 +1,577 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Filter narrows a Query/Count call. Zero-value fields are ignored.
+type Filter struct {
+	Since, Until time.Time
+	Severity     string
+	Host         string
+	AlertType    string
+	AUID         string
+	Query        string // substring match over Text/RawText, case-insensitive
+}
+
+func (f Filter) matches(a Alert) bool {
+	if !f.Since.IsZero() && a.ReceivedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && a.ReceivedAt.After(f.Until) {
+		return false
+	}
+	if f.Severity != "" && !strings.EqualFold(a.Severity, f.Severity) {
+		return false
+	}
+	if f.Host != "" && !strings.EqualFold(a.Host, f.Host) {
+		return false
+	}
+	if f.AlertType != "" && !strings.EqualFold(a.AlertType, f.AlertType) {
+		return false
+	}
+	if f.AUID != "" && !strings.EqualFold(a.AUID, f.AUID) {
+		return false
+	}
+	if f.Query != "" {
+		q := strings.ToLower(f.Query)
+		if !strings.Contains(strings.ToLower(a.Text), q) && !strings.Contains(strings.ToLower(a.RawText), q) {
+			return false
+		}
+	}
+	return true
+}
+
+// Page requests up to Limit alerts after Cursor (an opaque ID boundary
+// returned by a previous Query call as NextCursor).
+type Page struct {
+	Limit  int
+	Cursor string
+}
+
+// Store is the persistence backend for alerts. It replaces the old
+// alertsMu/alerts slice plus whole-file JSON snapshot, which had to
+// rewrite alerts_history.json on every single webhook.
+type Store interface {
+	Insert(a Alert) (Alert, error)
+	Get(id int) (Alert, bool, error)
+	// Touch applies fn to the stored alert with the given ID and persists
+	// the result. Used to bump Count/ReceivedAt when the rules engine
+	// collapses a burst into an existing alert instead of inserting a new one.
+	Touch(id int, fn func(*Alert)) (Alert, error)
+	Query(filter Filter, page Page) (alerts []Alert, nextCursor string, err error)
+	// ReplaySince returns every alert with ID > afterID, oldest first,
+	// matching filter. Used to replay missed events on SSE/WS reconnect.
+	ReplaySince(afterID int, filter Filter) ([]Alert, error)
+	Count(filter Filter) (int, error)
+	// Len reports the number of alerts currently held in the store in O(1),
+	// unlike Count(Filter{}) which scans every item even for an empty filter.
+	Len() int
+	Rotate(before time.Time) (archivedFile string, err error)
+	// Reload discards the in-memory index and re-reads it from the active
+	// segment on disk, picking up any out-of-band edits to that file.
+	Reload() error
+	Close() error
+	// DiskUsage reports the approximate size on disk of the active segment
+	// plus any rotated archives, for the splunk_history_bytes gauge.
+	DiskUsage() (int64, error)
+}
+
+// jsonlStore is the default backend: no CGO, no third-party driver. It keeps
+// an append-only JSONL segment on disk (so a crash mid-write only loses the
+// last partial line, never the whole history) backed by in-memory secondary
+// indexes on host, severity, alert_type and auid (plus ID order, which
+// tracks received_at since IDs are assigned in arrival order) so Query/Count
+// narrow to a candidate set before the final Filter.matches pass rather than
+// scanning every stored alert. A BoltDB or SQLite-backed Store would satisfy
+// the same interface for operators who want those indexes durable on disk,
+// but neither is vendored in this module.
+type jsonlStore struct {
+	mu   sync.RWMutex
+	dir  string
+	path string // active segment
+
+	items  []Alert // ascending by ID
+	byID   map[int]int
+	nextID int
+	count  int // len(items), maintained incrementally so Len() never scans
+
+	// Secondary indexes: field value (lower-cased) -> alert IDs in ascending
+	// order. Entries are appended, never removed in place, so a Touch that
+	// changes an indexed field just grows the old bucket with a stale ID;
+	// harmless because every candidate is re-checked against the live Alert
+	// via Filter.matches before it's returned.
+	byHost      map[string][]int
+	bySeverity  map[string][]int
+	byAlertType map[string][]int
+	byAUID      map[string][]int
+
+	retentionCount int
+	retentionAge   time.Duration
+	stopCompact    chan struct{}
+}
+
+func newJSONLStore(dir string, retentionCount int, retentionAge time.Duration) (*jsonlStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &jsonlStore{
+		dir:            dir,
+		path:           filepath.Join(dir, "alerts_current.jsonl"),
+		byID:           make(map[int]int),
+		nextID:         1,
+		byHost:         make(map[string][]int),
+		bySeverity:     make(map[string][]int),
+		byAlertType:    make(map[string][]int),
+		byAUID:         make(map[string][]int),
+		retentionCount: retentionCount,
+		retentionAge:   retentionAge,
+		stopCompact:    make(chan struct{}),
+	}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	go s.compactLoop()
+	return s, nil
+}
+
+func (s *jsonlStore) load() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var a Alert
+		if err := json.Unmarshal(line, &a); err != nil {
+			// A torn last line from a crash mid-append; stop rather than fail load.
+			logger.Warn("storage: skipping unreadable line", "path", s.path, "error", err)
+			continue
+		}
+		s.indexLocked(a)
+	}
+	return scanner.Err()
+}
+
+// indexLocked adds or overwrites a in the in-memory index. Caller must hold
+// s.mu. Re-indexing an existing ID (as Touch does) updates the slot in
+// place; the JSONL segment gets an extra line for it and the last line for
+// a given ID wins when the segment is reloaded from disk.
+func (s *jsonlStore) indexLocked(a Alert) {
+	if idx, ok := s.byID[a.ID]; ok {
+		s.items[idx] = a
+	} else {
+		s.byID[a.ID] = len(s.items)
+		s.items = append(s.items, a)
+		s.count++
+	}
+	if a.ID >= s.nextID {
+		s.nextID = a.ID + 1
+	}
+	addToIndex(s.byHost, a.Host, a.ID)
+	addToIndex(s.bySeverity, a.Severity, a.ID)
+	addToIndex(s.byAlertType, a.AlertType, a.ID)
+	addToIndex(s.byAUID, a.AUID, a.ID)
+}
+
+// addToIndex appends id to the bucket for key (case-insensitively), skipping
+// blank keys. Buckets are never pruned in place; see the jsonlStore doc
+// comment on why a stale entry from a later Touch is harmless.
+func addToIndex(idx map[string][]int, key string, id int) {
+	if key == "" {
+		return
+	}
+	key = strings.ToLower(key)
+	bucket := idx[key]
+	if n := len(bucket); n > 0 && bucket[n-1] == id {
+		return
+	}
+	idx[key] = append(bucket, id)
+}
+
+func (s *jsonlStore) appendLocked(a Alert) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, werr := f.Write(append(data, '\n'))
+	cerr := f.Close()
+	if werr != nil {
+		return werr
+	}
+	return cerr
+}
+
+func (s *jsonlStore) Touch(id int, fn func(*Alert)) (Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx, ok := s.byID[id]
+	if !ok {
+		return Alert{}, fmt.Errorf("alert %d not found", id)
+	}
+	a := s.items[idx]
+	fn(&a)
+	if err := s.appendLocked(a); err != nil {
+		return Alert{}, err
+	}
+	s.indexLocked(a)
+	return a, nil
+}
+
+func (s *jsonlStore) Insert(a Alert) (Alert, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	a.ID = s.nextID
+	s.nextID++
+
+	if err := s.appendLocked(a); err != nil {
+		return Alert{}, err
+	}
+	s.indexLocked(a)
+	return a, nil
+}
+
+func (s *jsonlStore) Get(id int) (Alert, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	idx, ok := s.byID[id]
+	if !ok {
+		return Alert{}, false, nil
+	}
+	return s.items[idx], true, nil
+}
+
+// candidatesLocked returns the ascending alert IDs that might satisfy
+// filter's Host/Severity/AlertType/AUID terms, narrowed through the
+// relevant secondary indexes, or nil if the filter has none of those terms
+// (meaning every stored alert is a candidate and callers should scan
+// s.items directly). The result may still contain IDs that don't actually
+// match once Since/Until/Query or a stale post-Touch index entry is taken
+// into account, so callers must always re-check with filter.matches.
+// Caller must hold s.mu (read or write).
+func (s *jsonlStore) candidatesLocked(filter Filter) []int {
+	var buckets [][]int
+	if filter.Host != "" {
+		buckets = append(buckets, s.byHost[strings.ToLower(filter.Host)])
+	}
+	if filter.Severity != "" {
+		buckets = append(buckets, s.bySeverity[strings.ToLower(filter.Severity)])
+	}
+	if filter.AlertType != "" {
+		buckets = append(buckets, s.byAlertType[strings.ToLower(filter.AlertType)])
+	}
+	if filter.AUID != "" {
+		buckets = append(buckets, s.byAUID[strings.ToLower(filter.AUID)])
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+	sort.Slice(buckets, func(i, j int) bool { return len(buckets[i]) < len(buckets[j]) })
+	driver := buckets[0]
+	if len(buckets) == 1 {
+		return driver
+	}
+
+	rest := make([]map[int]struct{}, len(buckets)-1)
+	for i, b := range buckets[1:] {
+		set := make(map[int]struct{}, len(b))
+		for _, id := range b {
+			set[id] = struct{}{}
+		}
+		rest[i] = set
+	}
+	out := make([]int, 0, len(driver))
+	for _, id := range driver {
+		in := true
+		for _, set := range rest {
+			if _, ok := set[id]; !ok {
+				in = false
+				break
+			}
+		}
+		if in {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func (s *jsonlStore) Query(filter Filter, page Page) ([]Alert, string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	limit := page.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	var before int
+	if page.Cursor != "" {
+		v, err := strconv.Atoi(page.Cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid cursor %q", page.Cursor)
+		}
+		before = v
+	}
+
+	out := make([]Alert, 0, limit)
+	var nextCursor string
+	consider := func(id int) bool { // false means stop scanning
+		idx, ok := s.byID[id]
+		if !ok {
+			return true
+		}
+		a := s.items[idx]
+		if before != 0 && a.ID >= before {
+			return true
+		}
+		if !filter.matches(a) {
+			return true
+		}
+		if len(out) == limit {
+			nextCursor = strconv.Itoa(a.ID + 1)
+			return false
+		}
+		out = append(out, a)
+		return true
+	}
+
+	if candidates := s.candidatesLocked(filter); candidates != nil {
+		for i := len(candidates) - 1; i >= 0; i-- {
+			if !consider(candidates[i]) {
+				break
+			}
+		}
+		return out, nextCursor, nil
+	}
+	for i := len(s.items) - 1; i >= 0; i-- {
+		if !consider(s.items[i].ID) {
+			break
+		}
+	}
+	return out, nextCursor, nil
+}
+
+func (s *jsonlStore) ReplaySince(afterID int, filter Filter) ([]Alert, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var out []Alert
+	consider := func(a Alert) {
+		if a.ID <= afterID {
+			return
+		}
+		if !filter.matches(a) {
+			return
+		}
+		out = append(out, a)
+	}
+
+	if candidates := s.candidatesLocked(filter); candidates != nil {
+		for _, id := range candidates {
+			if idx, ok := s.byID[id]; ok {
+				consider(s.items[idx])
+			}
+		}
+		return out, nil
+	}
+	for _, a := range s.items {
+		consider(a)
+	}
+	return out, nil
+}
+
+func (s *jsonlStore) Count(filter Filter) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	n := 0
+	if candidates := s.candidatesLocked(filter); candidates != nil {
+		for _, id := range candidates {
+			if idx, ok := s.byID[id]; ok && filter.matches(s.items[idx]) {
+				n++
+			}
+		}
+		return n, nil
+	}
+	for _, a := range s.items {
+		if filter.matches(a) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Rotate atomically archives every alert received before the given time into
+// its own timestamped JSONL file and rewrites the active segment with
+// whatever remains, rather than swapping the whole history wholesale.
+func (s *jsonlStore) Rotate(before time.Time) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var archived, kept []Alert
+	for _, a := range s.items {
+		if before.IsZero() || a.ReceivedAt.Before(before) {
+			archived = append(archived, a)
+		} else {
+			kept = append(kept, a)
+		}
+	}
+	if len(archived) == 0 {
+		return "", nil
+	}
+
+	ts := time.Now().UTC().Format("20060102-150405")
+	archiveFile := filepath.Join(s.dir, "alerts_archive_"+ts+".jsonl")
+	if err := writeJSONLAtomic(archiveFile, archived); err != nil {
+		return "", err
+	}
+	if err := writeJSONLAtomic(s.path, kept); err != nil {
+		return "", err
+	}
+
+	s.resetIndexesLocked()
+	for _, a := range kept {
+		s.indexLocked(a)
+	}
+	return archiveFile, nil
+}
+
+// resetIndexesLocked clears the in-memory index without touching anything
+// on disk. Caller must hold s.mu for writing and must re-populate via
+// indexLocked (directly or through load()) afterward.
+func (s *jsonlStore) resetIndexesLocked() {
+	s.items = s.items[:0]
+	s.byID = make(map[int]int)
+	s.byHost = make(map[string][]int)
+	s.bySeverity = make(map[string][]int)
+	s.byAlertType = make(map[string][]int)
+	s.byAUID = make(map[string][]int)
+	s.count = 0
+}
+
+// Len reports the number of alerts currently held in the store in O(1),
+// unlike Count(Filter{}) which scans every item even for an empty filter.
+func (s *jsonlStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.count
+}
+
+func writeJSONLAtomic(path string, alerts []Alert) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	for _, a := range alerts {
+		data, err := json.Marshal(a)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *jsonlStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resetIndexesLocked()
+	s.nextID = 1
+	return s.load()
+}
+
+func (s *jsonlStore) Close() error {
+	close(s.stopCompact)
+	return nil
+}
+
+func (s *jsonlStore) DiskUsage() (int64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		total += info.Size()
+	}
+	return total, nil
+}
+
+// compactLoop enforces the retention policy (by count and by age) in the
+// background so webhook requests never pay for compaction.
+func (s *jsonlStore) compactLoop() {
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCompact:
+			return
+		case <-ticker.C:
+			s.enforceRetention()
+		}
+	}
+}
+
+func (s *jsonlStore) enforceRetention() {
+	if s.retentionAge > 0 {
+		if _, err := s.Rotate(time.Now().Add(-s.retentionAge)); err != nil {
+			logger.Warn("storage: age-based retention rotate failed", "error", err)
+		}
+	}
+	if s.retentionCount > 0 {
+		s.mu.RLock()
+		overflow := len(s.items) - s.retentionCount
+		var cutoff time.Time
+		if overflow > 0 {
+			sorted := make([]Alert, len(s.items))
+			copy(sorted, s.items)
+			sort.Slice(sorted, func(i, j int) bool { return sorted[i].ReceivedAt.Before(sorted[j].ReceivedAt) })
+			cutoff = sorted[overflow-1].ReceivedAt.Add(time.Nanosecond)
+		}
+		s.mu.RUnlock()
+		if !cutoff.IsZero() {
+			if _, err := s.Rotate(cutoff); err != nil {
+				logger.Warn("storage: count-based retention rotate failed", "error", err)
+			}
+		}
+	}
+}
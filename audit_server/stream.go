@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Broker is a tiny in-process pub/sub: webhookHandler publishes each stored
+// alert, and every live SSE (or WS) client gets its own filtered channel.
+type Broker struct {
+	mu   sync.RWMutex
+	subs map[chan Alert]Filter
+}
+
+func newBroker() *Broker {
+	return &Broker{subs: make(map[chan Alert]Filter)}
+}
+
+// Subscribe registers a new client and returns the channel it should read
+// alerts from. Call Unsubscribe when the client disconnects.
+func (b *Broker) Subscribe(filter Filter) chan Alert {
+	ch := make(chan Alert, 16)
+	b.mu.Lock()
+	b.subs[ch] = filter
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *Broker) Unsubscribe(ch chan Alert) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
+
+// Publish fans an alert out to every subscriber whose filter matches. Slow
+// subscribers are dropped rather than blocking the webhook path.
+func (b *Broker) Publish(alert Alert) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch, filter := range b.subs {
+		if !filter.matches(alert) {
+			continue
+		}
+		select {
+		case ch <- alert:
+		default:
+		}
+	}
+}
+
+// Close disconnects every live subscriber, used during graceful shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		close(ch)
+	}
+	b.subs = make(map[chan Alert]Filter)
+}
+
+const sseHeartbeatInterval = 15 * time.Second
+
+// streamAlerts serves /api/alerts/stream as Server-Sent Events. Clients can
+// scope the stream with ?severity=&host=&alert_type=, and reconnect without
+// gaps by sending Last-Event-ID (replayed from the store before live events
+// start flowing).
+func streamAlerts(broker *Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		q := r.URL.Query()
+		filter := Filter{
+			Severity:  strings.TrimSpace(q.Get("severity")),
+			Host:      strings.TrimSpace(q.Get("host")),
+			AlertType: strings.TrimSpace(q.Get("alert_type")),
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+
+		if lastID := strings.TrimSpace(r.Header.Get("Last-Event-ID")); lastID != "" {
+			if id, err := strconv.Atoi(lastID); err == nil {
+				missed, err := store.ReplaySince(id, filter)
+				if err == nil {
+					for _, a := range missed {
+						writeSSEAlert(w, a)
+					}
+					flusher.Flush()
+				}
+			}
+		}
+
+		ch := broker.Subscribe(filter)
+		defer broker.Unsubscribe(ch)
+
+		heartbeat := time.NewTicker(sseHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case alert, ok := <-ch:
+				if !ok {
+					// Broker was closed (server shutting down).
+					return
+				}
+				writeSSEAlert(w, alert)
+				flusher.Flush()
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func writeSSEAlert(w http.ResponseWriter, a Alert) {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "id: %d\ndata: %s\n\n", a.ID, data)
+}
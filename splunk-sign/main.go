@@ -0,0 +1,49 @@
+// Command splunk-sign prints the HMAC signature header audit_server expects
+// on /webhook when senders.json is configured, so you can test a signed
+// request by hand:
+//
+//	echo '{"alert":"RED_EXEC","host":"db1"}' | splunk-sign -secret mysecret
+//	curl -H "X-Sender-Name: splunk-prod" -H "X-Splunk-Signature: $(...)" ...
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+func main() {
+	secret := flag.String("secret", "", "shared secret to sign with (required)")
+	bodyFile := flag.String("body", "", "path to the request body to sign (default: read stdin)")
+	flag.Parse()
+
+	if *secret == "" {
+		fmt.Fprintln(os.Stderr, "usage: splunk-sign -secret <secret> [-body <file>] < body.json")
+		os.Exit(2)
+	}
+
+	var body []byte
+	var err error
+	if *bodyFile != "" {
+		body, err = os.ReadFile(*bodyFile)
+	} else {
+		body, err = io.ReadAll(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error reading body:", err)
+		os.Exit(1)
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(*secret))
+	mac.Write([]byte(ts + "." + string(body)))
+	sig := hex.EncodeToString(mac.Sum(nil))
+
+	fmt.Printf("t=%s,v1=%s\n", ts, sig)
+}